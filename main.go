@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/honestbee/drone-helm-repo/pkg/util"
+)
+
+// logger is shared by Plugin.Exec and the storage backends so info/error
+// output from every stage of a build goes through the same streams.
+var logger = util.NewLogger()
+
+func main() {
+	plugin := Plugin{Config: configFromEnv()}
+	if err := plugin.Exec(); err != nil {
+		logger.Err.Println(err)
+		os.Exit(1)
+	}
+}
+
+// configFromEnv builds a Config from the PLUGIN_* environment variables
+// Drone sets from a pipeline step's `settings` block, plus the AWS_*
+// variables Drone forwards from `environment`/secrets.
+func configFromEnv() Config {
+	return Config{
+		SourceDir:            getenv("PLUGIN_SOURCE_DIR", "."),
+		Exclude:              splitList(os.Getenv("PLUGIN_EXCLUDE")),
+		StorageURL:           os.Getenv("PLUGIN_STORAGE_URL"),
+		RepoURL:              os.Getenv("PLUGIN_REPO_URL"),
+		Debug:                getenvBool("PLUGIN_DEBUG"),
+		AWSAccessKey:         firstNonEmpty(os.Getenv("PLUGIN_AWS_ACCESS_KEY"), os.Getenv("AWS_ACCESS_KEY_ID")),
+		AWSSecretKey:         firstNonEmpty(os.Getenv("PLUGIN_AWS_SECRET_KEY"), os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		AWSRegion:            firstNonEmpty(os.Getenv("PLUGIN_AWS_REGION"), os.Getenv("AWS_REGION")),
+		AWSProfile:           os.Getenv("PLUGIN_AWS_PROFILE"),
+		AWSEndpoint:          os.Getenv("PLUGIN_AWS_ENDPOINT"),
+		AWSDisableSSL:        getenvBool("PLUGIN_AWS_DISABLE_SSL"),
+		AWSForcePathStyle:    getenvBool("PLUGIN_AWS_FORCE_PATH_STYLE"),
+		AWSACL:               os.Getenv("PLUGIN_AWS_ACL"),
+		AWSCacheControl:      os.Getenv("PLUGIN_AWS_CACHE_CONTROL"),
+		AWSSSE:               os.Getenv("PLUGIN_AWS_SSE"),
+		AWSKMSKeyID:          os.Getenv("PLUGIN_AWS_KMS_KEY_ID"),
+		GCSCredentialsFile:   os.Getenv("PLUGIN_GCS_CREDENTIALS_FILE"),
+		AzureStorageAccount:  os.Getenv("PLUGIN_AZURE_STORAGE_ACCOUNT"),
+		AzureStorageKey:      os.Getenv("PLUGIN_AZURE_STORAGE_KEY"),
+		AzureSASToken:        os.Getenv("PLUGIN_AZURE_SAS_TOKEN"),
+		Force:                getenvBool("PLUGIN_FORCE"),
+		AcquireLock:          getenvBool("PLUGIN_ACQUIRE_LOCK"),
+		Concurrency:          getenvInt("PLUGIN_CONCURRENCY"),
+		UploadTimeoutSeconds: getenvInt("PLUGIN_UPLOAD_TIMEOUT_SECONDS"),
+		DryRun:               getenvBool("PLUGIN_DRY_RUN"),
+	}
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func getenvBool(key string) bool {
+	v, _ := strconv.ParseBool(os.Getenv(key))
+	return v
+}
+
+func getenvInt(key string) int {
+	v, _ := strconv.Atoi(os.Getenv(key))
+	return v
+}
+
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	parts := strings.Split(v, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
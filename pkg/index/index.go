@@ -0,0 +1,199 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"k8s.io/helm/pkg/repo"
+
+	"github.com/honestbee/drone-helm-repo/pkg/storage"
+	"github.com/honestbee/drone-helm-repo/pkg/util"
+)
+
+// FileName is the well-known name Helm repositories use for their index.
+const FileName = "index.yaml"
+
+// Merger builds an updated index.yaml from the chart archives found in a
+// directory, merging them into an existing index when one is supplied. It
+// is a separate interface from storage.ObjectStore so the merge logic can
+// be exercised against a local directory in tests, without an S3 bucket.
+//
+// Building the index is split into two steps so the "chart version already
+// exists" guard runs, and can fail, before any archive is uploaded: Index
+// indexes chartDir and checks it against existing, and must be called
+// before the archives chartDir holds are pushed to storage; Merge folds in
+// the digests computed during that upload and combines the result with
+// existing.
+type Merger interface {
+	Index(chartDir, repoURL string, existing *repo.IndexFile, force bool) (*repo.IndexFile, error)
+	Merge(existing, newIndex *repo.IndexFile, digests map[string]string) *repo.IndexFile
+}
+
+// HelmMerger implements Merger using Helm's own repo indexing logic.
+type HelmMerger struct{}
+
+// Index indexes chartDir with repoURL as the base URL for download links.
+// Versions already present in existing are refused unless force is set,
+// matching Helm repo semantics of never overwriting a published chart
+// version.
+func (HelmMerger) Index(chartDir, repoURL string, existing *repo.IndexFile, force bool) (*repo.IndexFile, error) {
+	newIndex, err := repo.IndexDirectory(chartDir, repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not index %s: %v", chartDir, err)
+	}
+	if err := checkOverwrite(newIndex, existing, force); err != nil {
+		return nil, err
+	}
+	return newIndex, nil
+}
+
+// checkOverwrite refuses newIndex if it carries any chart version already
+// present in existing, unless force is set, matching Helm repo semantics of
+// never overwriting a published chart version.
+func checkOverwrite(newIndex, existing *repo.IndexFile, force bool) error {
+	if existing == nil || force {
+		return nil
+	}
+	for name, versions := range newIndex.Entries {
+		for _, v := range versions {
+			if existing.Has(name, v.Version) {
+				return fmt.Errorf("chart %s-%s already exists in the index, refusing to overwrite (set Config.Force to override)", name, v.Version)
+			}
+		}
+	}
+	return nil
+}
+
+// Merge folds digests (the SHA-256 computed for each archive during upload,
+// keyed by archive file name) into newIndex and combines it with existing,
+// when supplied.
+func (HelmMerger) Merge(existing, newIndex *repo.IndexFile, digests map[string]string) *repo.IndexFile {
+	applyDigests(newIndex, digests)
+
+	if existing == nil {
+		newIndex.SortEntries()
+		return newIndex
+	}
+
+	// Helm's IndexFile.Merge keeps the existing record for a version that is
+	// already present, so drop the versions newIndex replaces first (only
+	// possible when Index was called with force) to carry the new entry.
+	for name := range newIndex.Entries {
+		kept := existing.Entries[name][:0]
+		for _, v := range existing.Entries[name] {
+			if !newIndex.Has(name, v.Version) {
+				kept = append(kept, v)
+			}
+		}
+		existing.Entries[name] = kept
+	}
+	existing.Merge(newIndex)
+	existing.SortEntries()
+	return existing
+}
+
+func applyDigests(idx *repo.IndexFile, digests map[string]string) {
+	for _, versions := range idx.Entries {
+		for _, v := range versions {
+			if len(v.URLs) == 0 {
+				continue
+			}
+			if d, ok := digests[filepath.Base(v.URLs[0])]; ok {
+				v.Digest = d
+			}
+		}
+	}
+}
+
+// Locker guards index.yaml updates against two concurrent builds racing
+// each other. It is implemented by storage backends that support a
+// conditional-write primitive; backends that don't can still be used as
+// long as Config.AcquireLock is left false.
+type Locker interface {
+	AcquireLock(name string) (release func() error, err error)
+}
+
+// Update downloads the existing index.yaml from store (if any) and indexes
+// the charts packaged under chartDir, refusing up front if any of them
+// would overwrite a version already published. Only once that check has
+// passed does it call upload, which is expected to push the packaged chart
+// archives to store and return the SHA-256 digest computed for each one
+// (keyed by archive file name); the merged index is then written and
+// uploaded back. Gating upload on the overwrite check this way means a
+// conflict is caught before anything is pushed to storage. When
+// acquireLock is true, store must implement Locker, and the whole sequence
+// is guarded by a lock object so two concurrent builds can't corrupt
+// index.yaml.
+func Update(ctx context.Context, store storage.ObjectStore, merger Merger, chartDir, repoURL string, force, acquireLock bool, upload func() (map[string]string, error), logger *util.Logger) error {
+	if acquireLock {
+		locker, ok := store.(Locker)
+		if !ok {
+			return fmt.Errorf("acquire-lock requested but the configured storage backend does not support locking")
+		}
+		release, err := locker.AcquireLock(FileName)
+		if err != nil {
+			return fmt.Errorf("could not acquire index lock: %v", err)
+		}
+		defer func() {
+			if err := release(); err != nil {
+				logger.Err.Println(err)
+			}
+		}()
+	}
+
+	existing, err := downloadIndex(ctx, store, chartDir)
+	if err != nil {
+		return err
+	}
+
+	newIndex, err := merger.Index(chartDir, repoURL, existing, force)
+	if err != nil {
+		return err
+	}
+
+	digests, err := upload()
+	if err != nil {
+		return err
+	}
+
+	merged := merger.Merge(existing, newIndex, digests)
+	dest := filepath.Join(chartDir, FileName)
+	if err := merged.WriteFile(dest, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %v", FileName, err)
+	}
+
+	logger.Out.Printf("uploading %s\n", FileName)
+	return store.StoreFile(ctx, &util.FileStat{Path: dest, Name: FileName}, logger)
+}
+
+func downloadIndex(ctx context.Context, store storage.ObjectStore, chartDir string) (*repo.IndexFile, error) {
+	r, err := store.FetchFile(ctx, FileName)
+	if err == storage.ErrNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch existing %s: %v", FileName, err)
+	}
+	defer r.Close()
+
+	f, err := ioutil.TempFile(chartDir, "existing-index")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return nil, err
+	}
+
+	existing, err := repo.LoadIndexFile(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("could not parse existing %s: %v", FileName, err)
+	}
+	return existing, nil
+}
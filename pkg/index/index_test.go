@@ -0,0 +1,109 @@
+package index
+
+import (
+	"testing"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+func newIndexWith(name, version, digest string, urls ...string) *repo.IndexFile {
+	idx := repo.NewIndexFile()
+	idx.Add(&chart.Metadata{Name: name, Version: version}, "", "", digest)
+	if len(urls) > 0 {
+		idx.Entries[name][0].URLs = urls
+	}
+	return idx
+}
+
+func TestCheckOverwrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		newIndex *repo.IndexFile
+		existing *repo.IndexFile
+		force    bool
+		wantErr  bool
+	}{
+		{
+			name:     "no existing index",
+			newIndex: newIndexWith("mychart", "1.0.0", ""),
+			existing: nil,
+			wantErr:  false,
+		},
+		{
+			name:     "new version, no conflict",
+			newIndex: newIndexWith("mychart", "2.0.0", ""),
+			existing: newIndexWith("mychart", "1.0.0", ""),
+			wantErr:  false,
+		},
+		{
+			name:     "version already published, refused",
+			newIndex: newIndexWith("mychart", "1.0.0", ""),
+			existing: newIndexWith("mychart", "1.0.0", ""),
+			wantErr:  true,
+		},
+		{
+			name:     "version already published, force overrides",
+			newIndex: newIndexWith("mychart", "1.0.0", ""),
+			existing: newIndexWith("mychart", "1.0.0", ""),
+			force:    true,
+			wantErr:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkOverwrite(tt.newIndex, tt.existing, tt.force)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkOverwrite() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyDigests(t *testing.T) {
+	idx := newIndexWith("mychart", "1.0.0", "", "mychart-1.0.0.tgz")
+
+	applyDigests(idx, map[string]string{"mychart-1.0.0.tgz": "deadbeef"})
+
+	got := idx.Entries["mychart"][0].Digest
+	if got != "deadbeef" {
+		t.Fatalf("applyDigests() digest = %q, want %q", got, "deadbeef")
+	}
+}
+
+func TestApplyDigests_NoMatchLeavesDigestUntouched(t *testing.T) {
+	idx := newIndexWith("mychart", "1.0.0", "original", "mychart-1.0.0.tgz")
+
+	applyDigests(idx, map[string]string{"other-1.0.0.tgz": "deadbeef"})
+
+	got := idx.Entries["mychart"][0].Digest
+	if got != "original" {
+		t.Fatalf("applyDigests() digest = %q, want unchanged %q", got, "original")
+	}
+}
+
+func TestHelmMerger_Merge(t *testing.T) {
+	newIndex := newIndexWith("mychart", "2.0.0", "", "mychart-2.0.0.tgz")
+	existing := newIndexWith("mychart", "1.0.0", "", "mychart-1.0.0.tgz")
+
+	merged := HelmMerger{}.Merge(existing, newIndex, map[string]string{"mychart-2.0.0.tgz": "abc123"})
+
+	versions := merged.Entries["mychart"]
+	if len(versions) != 2 {
+		t.Fatalf("Merge() produced %d versions for mychart, want 2", len(versions))
+	}
+	if !merged.Has("mychart", "1.0.0") || !merged.Has("mychart", "2.0.0") {
+		t.Fatalf("Merge() did not preserve both versions: %+v", versions)
+	}
+}
+
+func TestHelmMerger_Merge_NoExisting(t *testing.T) {
+	newIndex := newIndexWith("mychart", "1.0.0", "", "mychart-1.0.0.tgz")
+
+	merged := HelmMerger{}.Merge(nil, newIndex, nil)
+
+	if !merged.Has("mychart", "1.0.0") {
+		t.Fatalf("Merge() with no existing index dropped the new entry")
+	}
+}
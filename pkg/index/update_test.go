@@ -0,0 +1,95 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"k8s.io/helm/pkg/repo"
+
+	"github.com/honestbee/drone-helm-repo/pkg/storage"
+	"github.com/honestbee/drone-helm-repo/pkg/util"
+)
+
+// fakeStore is a minimal storage.ObjectStore for exercising Update without
+// any real backend. FetchFile always reports no existing index.yaml.
+type fakeStore struct {
+	stored []string
+}
+
+func (f *fakeStore) StoreFile(ctx context.Context, file *util.FileStat, logger *util.Logger) error {
+	f.stored = append(f.stored, file.Name)
+	return nil
+}
+
+func (f *fakeStore) FetchFile(ctx context.Context, name string) (io.ReadCloser, error) {
+	return nil, storage.ErrNotExist
+}
+
+// fakeMerger lets a test control whether Index refuses the update, without
+// needing real packaged chart archives on disk.
+type fakeMerger struct {
+	indexErr error
+}
+
+func (m fakeMerger) Index(chartDir, repoURL string, existing *repo.IndexFile, force bool) (*repo.IndexFile, error) {
+	if m.indexErr != nil {
+		return nil, m.indexErr
+	}
+	return repo.NewIndexFile(), nil
+}
+
+func (m fakeMerger) Merge(existing, newIndex *repo.IndexFile, digests map[string]string) *repo.IndexFile {
+	return newIndex
+}
+
+func TestUpdate_DoesNotUploadWhenIndexRefuses(t *testing.T) {
+	chartDir, err := ioutil.TempDir("", "index-update-test")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+
+	store := &fakeStore{}
+	uploadCalled := false
+	upload := func() (map[string]string, error) {
+		uploadCalled = true
+		return nil, nil
+	}
+
+	err = Update(context.Background(), store, fakeMerger{indexErr: errors.New("chart already exists")}, chartDir, "https://charts.example.com", false, false, upload, util.NewLogger())
+	if err == nil {
+		t.Fatal("Update() error = nil, want the overwrite refusal")
+	}
+	if uploadCalled {
+		t.Fatal("Update() called upload() even though the overwrite check refused the update")
+	}
+	if len(store.stored) != 0 {
+		t.Fatalf("Update() stored %v, want nothing uploaded", store.stored)
+	}
+}
+
+func TestUpdate_UploadsOnlyAfterOverwriteCheckPasses(t *testing.T) {
+	chartDir, err := ioutil.TempDir("", "index-update-test")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+
+	store := &fakeStore{}
+	uploadCalled := false
+	upload := func() (map[string]string, error) {
+		uploadCalled = true
+		return map[string]string{}, nil
+	}
+
+	if err := Update(context.Background(), store, fakeMerger{}, chartDir, "https://charts.example.com", false, false, upload, util.NewLogger()); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if !uploadCalled {
+		t.Fatal("Update() never called upload() after the overwrite check passed")
+	}
+	if len(store.stored) != 1 || store.stored[0] != FileName {
+		t.Fatalf("Update() stored %v, want [%s]", store.stored, FileName)
+	}
+}
@@ -0,0 +1,158 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+
+	"github.com/honestbee/drone-helm-repo/pkg/storage"
+	"github.com/honestbee/drone-helm-repo/pkg/util"
+)
+
+// Config holds the Azure Blob Storage settings needed to authenticate and
+// target a container.
+type Config struct {
+	Account   string
+	AccessKey string
+	// SASToken, when set, is appended to the container URL as its query
+	// string. The pipeline credential is built from AccessKey when that is
+	// set, and is anonymous otherwise. Account is needed either way for
+	// azblob:// URIs.
+	SASToken string
+	// AzureURI is either an azblob:// URI or a full
+	// https://<account>.blob.core.windows.net/<container>/<prefix> URL.
+	AzureURI string
+}
+
+// ObjectStore uploads packaged charts to an Azure Blob Storage container.
+type ObjectStore struct {
+	container *azblob.ContainerURL
+	prefix    string
+}
+
+// CreateAzureObjectStore parses Config.AzureURI and returns an ObjectStore
+// backed by a container URL authenticated from AccessKey or SASToken.
+func CreateAzureObjectStore(conf *Config) (*ObjectStore, error) {
+	account, container, prefix, err := parseAzureURI(conf.AzureURI, conf.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	containerURL, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", account, container))
+	if err != nil {
+		return nil, fmt.Errorf("could not build container url: %v", err)
+	}
+	if conf.SASToken != "" {
+		containerURL.RawQuery = conf.SASToken
+	}
+
+	var credential azblob.Credential
+	if conf.AccessKey != "" {
+		credential, err = azblob.NewSharedKeyCredential(account, conf.AccessKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not create azure credential: %v", err)
+		}
+	} else {
+		// SAS-authenticated requests carry their signature in the URL
+		// query (set above), so the pipeline itself needs no credential.
+		credential = azblob.NewAnonymousCredential()
+	}
+
+	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+	cu := azblob.NewContainerURL(*containerURL, pipeline)
+
+	return &ObjectStore{
+		container: &cu,
+		prefix:    prefix,
+	}, nil
+}
+
+// StoreFile uploads file to the container configured for this ObjectStore,
+// setting its Content-Type and recording its SHA-256 on file.Digest.
+func (o *ObjectStore) StoreFile(ctx context.Context, file *util.FileStat, logger *util.Logger) error {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sha256sum, err := util.SHA256File(file.Path)
+	if err != nil {
+		return err
+	}
+
+	key := o.key(file.Name)
+	logger.Out.Printf("uploading %s to azblob://%s/%s\n", file.Path, o.container.URL().Path, key)
+	blockBlobURL := o.container.NewBlockBlobURL(key)
+	_, err = azblob.UploadFileToBlockBlob(ctx, f, blockBlobURL, azblob.UploadToBlockBlobOptions{
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{ContentType: util.ContentType(file.Name)},
+		Metadata:        azblob.Metadata{"sha256": sha256sum},
+	})
+	if err != nil {
+		return err
+	}
+	file.Digest = sha256sum
+	return nil
+}
+
+// FetchFile returns a reader for name, or storage.ErrNotExist if it is not
+// present in the container.
+func (o *ObjectStore) FetchFile(ctx context.Context, name string) (io.ReadCloser, error) {
+	blockBlobURL := o.container.NewBlockBlobURL(o.key(name))
+	resp, err := blockBlobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		if stgErr, ok := err.(azblob.StorageError); ok && stgErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil, storage.ErrNotExist
+		}
+		return nil, err
+	}
+	return resp.Body(azblob.RetryReaderOptions{}), nil
+}
+
+func (o *ObjectStore) key(name string) string {
+	if o.prefix == "" {
+		return name
+	}
+	return strings.Trim(o.prefix, "/") + "/" + name
+}
+
+// parseAzureURI accepts either azblob://container/prefix (account supplied
+// separately via Config.Account) or a full
+// https://<account>.blob.core.windows.net/<container>/<prefix> URL.
+func parseAzureURI(uri, configuredAccount string) (account, container, prefix string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", "", fmt.Errorf("could not parse azure uri %q: %v", uri, err)
+	}
+
+	switch u.Scheme {
+	case "azblob":
+		// azblob://<container>/<prefix> puts the container in the host,
+		// same as s3://<bucket>/<prefix> and gs://<bucket>/<prefix>.
+		if configuredAccount == "" {
+			return "", "", "", fmt.Errorf("storage account required for azblob:// urls")
+		}
+		return configuredAccount, u.Host, strings.TrimPrefix(u.Path, "/"), nil
+	case "https":
+		host := strings.TrimSuffix(u.Host, ".blob.core.windows.net")
+		if host == u.Host {
+			return "", "", "", fmt.Errorf("unsupported azure storage host %q", u.Host)
+		}
+		// https://<account>.blob.core.windows.net/<container>/<prefix>
+		// legitimately carries the container in the path.
+		path := strings.Trim(u.Path, "/")
+		parts := strings.SplitN(path, "/", 2)
+		container = parts[0]
+		if len(parts) == 2 {
+			prefix = parts[1]
+		}
+		return host, container, prefix, nil
+	default:
+		return "", "", "", fmt.Errorf("unsupported azure storage scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,70 @@
+package azure
+
+import "testing"
+
+func TestParseAzureURI(t *testing.T) {
+	tests := []struct {
+		name              string
+		uri               string
+		configuredAccount string
+		wantAccount       string
+		wantContainer     string
+		wantPrefix        string
+		wantErr           bool
+	}{
+		{
+			name:              "azblob container only",
+			uri:               "azblob://mycontainer",
+			configuredAccount: "myaccount",
+			wantAccount:       "myaccount",
+			wantContainer:     "mycontainer",
+			wantPrefix:        "",
+		},
+		{
+			name:              "azblob container with prefix",
+			uri:               "azblob://mycontainer/charts/stable",
+			configuredAccount: "myaccount",
+			wantAccount:       "myaccount",
+			wantContainer:     "mycontainer",
+			wantPrefix:        "charts/stable",
+		},
+		{
+			name:    "azblob without configured account",
+			uri:     "azblob://mycontainer",
+			wantErr: true,
+		},
+		{
+			name:          "https form with container and prefix",
+			uri:           "https://myaccount.blob.core.windows.net/mycontainer/charts/stable",
+			wantAccount:   "myaccount",
+			wantContainer: "mycontainer",
+			wantPrefix:    "charts/stable",
+		},
+		{
+			name:    "unsupported https host",
+			uri:     "https://example.com/mycontainer",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported scheme",
+			uri:     "s3://mycontainer",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account, container, prefix, err := parseAzureURI(tt.uri, tt.configuredAccount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAzureURI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if account != tt.wantAccount || container != tt.wantContainer || prefix != tt.wantPrefix {
+				t.Fatalf("parseAzureURI() = (%q, %q, %q), want (%q, %q, %q)",
+					account, container, prefix, tt.wantAccount, tt.wantContainer, tt.wantPrefix)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	gstorage "cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+
+	"github.com/honestbee/drone-helm-repo/pkg/storage"
+	"github.com/honestbee/drone-helm-repo/pkg/util"
+)
+
+// Config holds the GCS-specific settings needed to authenticate and target
+// a bucket.
+type Config struct {
+	// CredentialsFile is the path to a GOOGLE_APPLICATION_CREDENTIALS
+	// service-account JSON file. When empty, the SDK's default credential
+	// chain is used.
+	CredentialsFile string
+	GCSURI          string
+}
+
+// ObjectStore uploads packaged charts to a Google Cloud Storage bucket.
+type ObjectStore struct {
+	bucket string
+	prefix string
+	client *gstorage.Client
+}
+
+// CreateGCSObjectStore parses Config.GCSURI and returns an ObjectStore
+// backed by a GCS client authenticated from CredentialsFile, or the
+// application default credentials when it is empty.
+func CreateGCSObjectStore(conf *Config) (*ObjectStore, error) {
+	bucket, prefix, err := parseGCSURI(conf.GCSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []option.ClientOption
+	if conf.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(conf.CredentialsFile))
+	}
+
+	client, err := gstorage.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create gcs client: %v", err)
+	}
+
+	return &ObjectStore{
+		bucket: bucket,
+		prefix: prefix,
+		client: client,
+	}, nil
+}
+
+// StoreFile uploads file to the bucket configured for this ObjectStore,
+// setting its Content-Type and recording its SHA-256 on file.Digest.
+func (o *ObjectStore) StoreFile(ctx context.Context, file *util.FileStat, logger *util.Logger) error {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sha256sum, err := util.SHA256File(file.Path)
+	if err != nil {
+		return err
+	}
+
+	key := o.key(file.Name)
+	logger.Out.Printf("uploading %s to gs://%s/%s\n", file.Path, o.bucket, key)
+	w := o.client.Bucket(o.bucket).Object(key).NewWriter(ctx)
+	w.ContentType = util.ContentType(file.Name)
+	w.Metadata = map[string]string{"sha256": sha256sum}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	file.Digest = sha256sum
+	return nil
+}
+
+// FetchFile returns a reader for name, or storage.ErrNotExist if it is not
+// present in the bucket.
+func (o *ObjectStore) FetchFile(ctx context.Context, name string) (io.ReadCloser, error) {
+	r, err := o.client.Bucket(o.bucket).Object(o.key(name)).NewReader(ctx)
+	if err != nil {
+		if err == gstorage.ErrObjectNotExist {
+			return nil, storage.ErrNotExist
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+func (o *ObjectStore) key(name string) string {
+	if o.prefix == "" {
+		return name
+	}
+	return strings.Trim(o.prefix, "/") + "/" + name
+}
+
+func parseGCSURI(uri string) (bucket, prefix string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", fmt.Errorf("could not parse gs uri %q: %v", uri, err)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
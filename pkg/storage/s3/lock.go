@@ -0,0 +1,45 @@
+package s3
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// AcquireLock implements index.Locker for S3. S3 has no native
+// If-None-Match support on PUT, so this follows the same approach as
+// helm-s3: check whether the lock object exists with HeadObject and, if
+// not, race to create it. It is best-effort rather than a true compare-and-
+// swap, but narrows the window two concurrent builds can collide in.
+func (o *ObjectStore) AcquireLock(name string) (func() error, error) {
+	lockKey := o.key(name + ".lock")
+
+	_, err := o.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(lockKey),
+	})
+	if err == nil {
+		return nil, fmt.Errorf("lock %q already held", lockKey)
+	}
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NotFound" {
+		return nil, err
+	}
+
+	if _, err := o.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(lockKey),
+	}); err != nil {
+		return nil, fmt.Errorf("could not acquire lock %q: %v", lockKey, err)
+	}
+
+	release := func() error {
+		_, err := o.client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(o.bucket),
+			Key:    aws.String(lockKey),
+		})
+		return err
+	}
+	return release, nil
+}
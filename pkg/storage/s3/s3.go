@@ -0,0 +1,246 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/honestbee/drone-helm-repo/pkg/storage"
+	"github.com/honestbee/drone-helm-repo/pkg/util"
+)
+
+// Config holds the S3-specific settings needed to authenticate and target a
+// bucket. AccessKey/SecretKey may be left empty to fall back to the AWS
+// SDK's default credential chain (env vars, shared credentials file,
+// EC2/IRSA instance role).
+type Config struct {
+	AccessKey string
+	SecretKey string
+	Region    string
+	S3URI     string
+	// Profile selects a named profile from the shared credentials file
+	// when AccessKey/SecretKey are empty.
+	Profile string
+	// Endpoint overrides the AWS S3 endpoint, for S3-compatible services
+	// such as MinIO, DigitalOcean Spaces or Ceph. May also be supplied via
+	// the `endpoint` query parameter on S3URI.
+	Endpoint string
+	// DisableSSL talks to Endpoint over plain HTTP. May also be supplied
+	// via the `disable-ssl` query parameter on S3URI.
+	DisableSSL bool
+	// ForcePathStyle addresses buckets as
+	// https://endpoint/bucket instead of https://bucket.endpoint, which
+	// most S3-compatible services require. May also be supplied via the
+	// `path-style` query parameter on S3URI.
+	ForcePathStyle bool
+	// ACL sets the canned ACL applied to uploaded objects, e.g.
+	// "public-read". Left unset, the bucket default applies.
+	ACL string
+	// CacheControl sets the Cache-Control header on uploaded objects, e.g.
+	// "public, max-age=300" for a repo served behind a CDN.
+	CacheControl string
+	// SSE selects server-side encryption: "AES256" or "aws:kms". Left
+	// empty, objects are not explicitly encrypted by this plugin.
+	SSE string
+	// KMSKeyID is the KMS key to use when SSE is "aws:kms". Left empty,
+	// the bucket's default KMS key is used.
+	KMSKeyID string
+}
+
+// ObjectStore uploads packaged charts to an S3 (or S3-compatible) bucket.
+type ObjectStore struct {
+	bucket       string
+	prefix       string
+	acl          string
+	cacheControl string
+	sse          string
+	kmsKeyID     string
+	client       *s3.S3
+	uploader     *s3manager.Uploader
+}
+
+// CreateS3ObjectStore parses Config.S3URI and returns an ObjectStore backed
+// by the AWS SDK session built from the supplied credentials, region and
+// endpoint.
+func CreateS3ObjectStore(conf *Config) (*ObjectStore, error) {
+	bucket, prefix, _, err := parseS3URI(conf.S3URI)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, disableSSL, forcePathStyle, err := ResolveOptions(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	awsConfig := aws.NewConfig().WithRegion(conf.Region).WithDisableSSL(disableSSL).WithS3ForcePathStyle(forcePathStyle)
+	if endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(endpoint)
+	}
+	if conf.AccessKey != "" && conf.SecretKey != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(conf.AccessKey, conf.SecretKey, ""))
+	} else if conf.Profile != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewSharedCredentials("", conf.Profile))
+	}
+	// otherwise leave credentials unset: the session below falls back to
+	// the SDK's default chain (env vars, shared credentials file,
+	// EC2/IRSA instance role).
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not create aws session: %v", err)
+	}
+
+	return &ObjectStore{
+		bucket:       bucket,
+		prefix:       prefix,
+		acl:          conf.ACL,
+		cacheControl: conf.CacheControl,
+		sse:          conf.SSE,
+		kmsKeyID:     conf.KMSKeyID,
+		client:       s3.New(sess),
+		uploader:     s3manager.NewUploader(sess),
+	}, nil
+}
+
+// StoreFile uploads file to the bucket configured for this ObjectStore,
+// setting Content-Type, Content-MD5 and an x-amz-meta-sha256 digest so the
+// upload can be integrity-checked, plus whatever ACL/Cache-Control/SSE
+// options were configured.
+func (o *ObjectStore) StoreFile(ctx context.Context, file *util.FileStat, logger *util.Logger) error {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sha256sum, err := util.SHA256File(file.Path)
+	if err != nil {
+		return err
+	}
+	md5sum, err := md5File(file.Path)
+	if err != nil {
+		return err
+	}
+
+	key := o.key(file.Name)
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(o.bucket),
+		Key:         aws.String(key),
+		Body:        f,
+		ContentType: aws.String(util.ContentType(file.Name)),
+		ContentMD5:  aws.String(md5sum),
+		Metadata:    map[string]*string{"sha256": aws.String(sha256sum)},
+	}
+	if o.acl != "" {
+		input.ACL = aws.String(o.acl)
+	}
+	if o.cacheControl != "" {
+		input.CacheControl = aws.String(o.cacheControl)
+	}
+	if o.sse != "" {
+		input.ServerSideEncryption = aws.String(o.sse)
+		if o.kmsKeyID != "" {
+			input.SSEKMSKeyId = aws.String(o.kmsKeyID)
+		}
+	}
+
+	logger.Out.Printf("uploading %s to s3://%s/%s\n", file.Path, o.bucket, key)
+	if _, err := o.uploader.UploadWithContext(ctx, input); err != nil {
+		return err
+	}
+	file.Digest = sha256sum
+	return nil
+}
+
+func md5File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// FetchFile returns a reader for name, or storage.ErrNotExist if it is not
+// present in the bucket.
+func (o *ObjectStore) FetchFile(ctx context.Context, name string) (io.ReadCloser, error) {
+	out, err := o.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(o.key(name)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound") {
+			return nil, storage.ErrNotExist
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (o *ObjectStore) key(name string) string {
+	if o.prefix == "" {
+		return name
+	}
+	return strings.Trim(o.prefix, "/") + "/" + name
+}
+
+func parseS3URI(uri string) (bucket, prefix string, query url.Values, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("could not parse s3 uri %q: %v", uri, err)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), u.Query(), nil
+}
+
+// ResolveOptions merges conf.Endpoint/DisableSSL/ForcePathStyle with the
+// `endpoint`/`disable-ssl`/`path-style` query parameters on conf.S3URI, the
+// query parameters taking precedence when present. It is exported so
+// validateConfig can reject a malformed disable-ssl/path-style value before
+// the plugin starts uploading, rather than have it silently ignored here.
+func ResolveOptions(conf *Config) (endpoint string, disableSSL, forcePathStyle bool, err error) {
+	_, _, query, err := parseS3URI(conf.S3URI)
+	if err != nil {
+		return "", false, false, err
+	}
+
+	endpoint = conf.Endpoint
+	if v := query.Get("endpoint"); v != "" {
+		endpoint = v
+	}
+
+	disableSSL = conf.DisableSSL
+	if v := query.Get("disable-ssl"); v != "" {
+		disableSSL, err = strconv.ParseBool(v)
+		if err != nil {
+			return "", false, false, fmt.Errorf("invalid disable-ssl query parameter %q: %v", v, err)
+		}
+	}
+
+	forcePathStyle = conf.ForcePathStyle
+	if v := query.Get("path-style"); v != "" {
+		forcePathStyle, err = strconv.ParseBool(v)
+		if err != nil {
+			return "", false, false, fmt.Errorf("invalid path-style query parameter %q: %v", v, err)
+		}
+	}
+
+	return endpoint, disableSSL, forcePathStyle, nil
+}
@@ -0,0 +1,67 @@
+package s3
+
+import "testing"
+
+func TestParseS3URI(t *testing.T) {
+	bucket, prefix, query, err := parseS3URI("s3://mybucket/charts/stable?endpoint=minio.local:9000")
+	if err != nil {
+		t.Fatalf("parseS3URI() error = %v", err)
+	}
+	if bucket != "mybucket" {
+		t.Errorf("bucket = %q, want %q", bucket, "mybucket")
+	}
+	if prefix != "charts/stable" {
+		t.Errorf("prefix = %q, want %q", prefix, "charts/stable")
+	}
+	if got := query.Get("endpoint"); got != "minio.local:9000" {
+		t.Errorf("query endpoint = %q, want %q", got, "minio.local:9000")
+	}
+}
+
+func TestResolveOptions(t *testing.T) {
+	tests := []struct {
+		name               string
+		conf               *Config
+		wantEndpoint       string
+		wantDisableSSL     bool
+		wantForcePathStyle bool
+		wantErr            bool
+	}{
+		{
+			name:         "config defaults, no query overrides",
+			conf:         &Config{S3URI: "s3://mybucket", Endpoint: "s3.example.com", DisableSSL: true, ForcePathStyle: true},
+			wantEndpoint: "s3.example.com", wantDisableSSL: true, wantForcePathStyle: true,
+		},
+		{
+			name:         "query parameters override config",
+			conf:         &Config{S3URI: "s3://mybucket?endpoint=minio.local:9000&disable-ssl=true&path-style=true"},
+			wantEndpoint: "minio.local:9000", wantDisableSSL: true, wantForcePathStyle: true,
+		},
+		{
+			name:    "invalid disable-ssl value is rejected",
+			conf:    &Config{S3URI: "s3://mybucket?disable-ssl=yes"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid path-style value is rejected",
+			conf:    &Config{S3URI: "s3://mybucket?path-style=nope"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint, disableSSL, forcePathStyle, err := ResolveOptions(tt.conf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if endpoint != tt.wantEndpoint || disableSSL != tt.wantDisableSSL || forcePathStyle != tt.wantForcePathStyle {
+				t.Fatalf("ResolveOptions() = (%q, %v, %v), want (%q, %v, %v)",
+					endpoint, disableSSL, forcePathStyle, tt.wantEndpoint, tt.wantDisableSSL, tt.wantForcePathStyle)
+			}
+		})
+	}
+}
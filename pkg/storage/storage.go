@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/honestbee/drone-helm-repo/pkg/util"
+)
+
+// ObjectStore is implemented by every storage backend the plugin can push
+// packaged charts to. Implementations are selected by the scheme of the
+// configured storage URL and stay ignorant of one another.
+type ObjectStore interface {
+	// StoreFile uploads file, setting file.Digest to its SHA-256 on
+	// success so callers (e.g. index generation) don't need to re-hash it.
+	// ctx governs cancellation and per-call timeouts.
+	StoreFile(ctx context.Context, file *util.FileStat, logger *util.Logger) error
+	// FetchFile returns a reader for name, or ErrNotExist if no such
+	// object exists in the store. Callers are responsible for closing it.
+	FetchFile(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// ErrNotExist is returned by FetchFile when the requested object does not
+// exist in the store.
+var ErrNotExist = errNotExist("object does not exist")
+
+type errNotExist string
+
+func (e errNotExist) Error() string { return string(e) }
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FileStat describes a single file produced by PackageCharts and destined
+// for upload to the configured ObjectStore.
+type FileStat struct {
+	// Path is the local, on-disk location of the packaged file.
+	Path string
+	// Name is the file name as it should appear in the chart repository.
+	Name string
+	// Digest is the hex-encoded SHA-256 of the file contents, filled in by
+	// ObjectStore.StoreFile once the upload succeeds.
+	Digest string
+	// Err is set when the file could not be produced; storeFiles logs and
+	// skips entries carrying one.
+	Err error
+}
+
+// FindCharts walks sourceDir looking for directories that contain a
+// Chart.yaml, skipping any path under one of the exclude directories. It
+// returns a channel of chart directory paths and closes it once the walk is
+// done.
+func FindCharts(sourceDir string, exclude []string, logger *Logger) chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			for _, e := range exclude {
+				if e != "" && strings.Contains(path, e) {
+					return filepath.SkipDir
+				}
+			}
+			if _, err := os.Stat(filepath.Join(path, "Chart.yaml")); err == nil {
+				out <- path
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Err.Println(err)
+		}
+	}()
+	return out
+}
+
+// PackageCharts runs `helm package` against every chart received from
+// charts, writing the resulting archives into destDir. It returns a channel
+// of FileStat values, one per chart, and closes it once every chart has
+// been processed.
+func PackageCharts(charts chan string, destDir string, logger *Logger, repoURL string) chan *FileStat {
+	out := make(chan *FileStat)
+	go func() {
+		defer close(out)
+		for chart := range charts {
+			name, err := packageChart(chart, destDir, repoURL)
+			if err != nil {
+				out <- &FileStat{Err: err}
+				continue
+			}
+			out <- &FileStat{
+				Path: filepath.Join(destDir, name),
+				Name: name,
+			}
+		}
+	}()
+	return out
+}
+
+func packageChart(chartDir, destDir, repoURL string) (string, error) {
+	cmd := exec.Command("helm", "package", chartDir, "--destination", destDir)
+	if repoURL != "" {
+		cmd.Args = append(cmd.Args, "--dependency-update")
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	// `helm package` prints "Successfully packaged chart and saved it to: <path>"
+	packagedTo := strings.TrimSpace(string(output))
+	if idx := strings.LastIndex(packagedTo, ":"); idx != -1 {
+		return filepath.Base(strings.TrimSpace(packagedTo[idx+1:])), nil
+	}
+	return "", err
+}
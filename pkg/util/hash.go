@@ -0,0 +1,37 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// SHA256File returns the hex-encoded SHA-256 digest of the file at path.
+func SHA256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ContentType returns the MIME type to upload name with, based on its
+// extension. Helm chart archives are gzipped tarballs; index.yaml is plain
+// YAML.
+func ContentType(name string) string {
+	switch {
+	case len(name) >= 4 && name[len(name)-4:] == ".tgz":
+		return "application/gzip"
+	case len(name) >= 5 && name[len(name)-5:] == ".yaml":
+		return "application/x-yaml"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,22 @@
+package util
+
+import (
+	"log"
+	"os"
+)
+
+// Logger wraps the two output streams used throughout the plugin so that
+// informational and error messages can be routed independently.
+type Logger struct {
+	Out *log.Logger
+	Err *log.Logger
+}
+
+// NewLogger returns a Logger that writes info messages to stdout and error
+// messages to stderr.
+func NewLogger() *Logger {
+	return &Logger{
+		Out: log.New(os.Stdout, "", log.LstdFlags),
+		Err: log.New(os.Stderr, "", log.LstdFlags),
+	}
+}
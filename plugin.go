@@ -1,19 +1,37 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/honestbee/drone-helm-repo/pkg/index"
 	"github.com/honestbee/drone-helm-repo/pkg/storage"
+	"github.com/honestbee/drone-helm-repo/pkg/storage/azure"
+	"github.com/honestbee/drone-helm-repo/pkg/storage/gcs"
 	"github.com/honestbee/drone-helm-repo/pkg/storage/s3"
 	"github.com/honestbee/drone-helm-repo/pkg/util"
 )
 
+const (
+	defaultConcurrency   = 5
+	defaultUploadRetries = 5
+	defaultRetryBackoff  = 500 * time.Millisecond
+)
+
 var supportedStorageSchemes = [...]string{
 	"s3",
+	"gs",
+	"azblob",
 }
 
 type (
@@ -27,6 +45,53 @@ type (
 		AWSAccessKey string   `json:"aws_access_key"`
 		AWSSecretKey string   `json:"aws_secret_key"`
 		AWSRegion    string   `json:"aws_region"`
+		// AWSProfile selects a named profile from the shared credentials
+		// file when AWSAccessKey/AWSSecretKey are empty. If that is also
+		// empty, credentials fall back to the AWS SDK's default chain.
+		AWSProfile string `json:"aws_profile"`
+		// AWSEndpoint, AWSDisableSSL and AWSForcePathStyle target
+		// S3-compatible services such as MinIO, DigitalOcean Spaces or
+		// Ceph. They may also be set via `endpoint`, `disable-ssl` and
+		// `path-style` query parameters on StorageURL.
+		AWSEndpoint       string `json:"aws_endpoint"`
+		AWSDisableSSL     bool   `json:"aws_disable_ssl"`
+		AWSForcePathStyle bool   `json:"aws_force_path_style"`
+		// AWSACL, AWSCacheControl, AWSSSE and AWSKMSKeyID tune how objects
+		// are uploaded to S3, e.g. to host a public repo behind CloudFront.
+		AWSACL          string `json:"aws_acl"`
+		AWSCacheControl string `json:"aws_cache_control"`
+		AWSSSE          string `json:"aws_sse"`
+		AWSKMSKeyID     string `json:"aws_kms_key_id"`
+		// GCSCredentialsFile points at a GOOGLE_APPLICATION_CREDENTIALS
+		// service-account JSON file. Only used for gs:// storage URLs.
+		GCSCredentialsFile string `json:"gcs_credentials_file"`
+		// AzureStorageAccount and AzureStorageKey authenticate azblob://
+		// and https://*.blob.core.windows.net URLs. AzureSASToken may be
+		// used instead of AzureStorageKey.
+		AzureStorageAccount string `json:"azure_storage_account"`
+		AzureStorageKey     string `json:"azure_storage_key"`
+		AzureSASToken       string `json:"azure_sas_token"`
+		// Force allows overwriting chart versions that already exist in
+		// index.yaml. Refused by default, matching Helm repo semantics.
+		Force bool `json:"force"`
+		// AcquireLock guards the index.yaml read-modify-write against two
+		// concurrent builds racing each other. Requires a storage backend
+		// that supports locking (currently s3). Note that the s3 backend's
+		// lock is a best-effort HeadObject-then-PutObject check, not a true
+		// atomic conditional write (S3 has no native If-None-Match support
+		// on PUT), so it narrows but does not eliminate the window in which
+		// two builds can still race each other onto index.yaml.
+		AcquireLock bool `json:"acquire_lock"`
+		// Concurrency bounds how many uploads run at once. Defaults to
+		// defaultConcurrency when unset.
+		Concurrency int `json:"concurrency"`
+		// UploadTimeoutSeconds bounds how long each upload attempt of a
+		// single file may take; every retry gets the full timeout again.
+		// Zero means no per-file timeout.
+		UploadTimeoutSeconds int `json:"upload_timeout_seconds"`
+		// DryRun logs the uploads that would happen without calling
+		// StoreFile, and skips the index.yaml update entirely.
+		DryRun bool `json:"dry_run"`
 	}
 	// Plugin implements this Drone plugin functionality
 	Plugin struct {
@@ -46,7 +111,10 @@ func (p *Plugin) Exec() error {
 		return err
 	}
 
-	destinationURL, _ := url.Parse(p.Config.StorageURL)
+	destinationURL, err := url.Parse(p.Config.StorageURL)
+	if err != nil {
+		return err
+	}
 	// get a temp dir to store generated packages
 	tempDir, err := ioutil.TempDir("./", "tempOutput")
 	if err != nil {
@@ -55,17 +123,53 @@ func (p *Plugin) Exec() error {
 	defer os.RemoveAll(tempDir) // clean up
 
 	charts := util.FindCharts(p.Config.SourceDir, p.Config.Exclude, logger)
-	packages := util.PackageCharts(charts, tempDir, logger, p.Config.RepoURL)
+	// Drain PackageCharts fully before uploading anything: the index
+	// overwrite guard in index.Update needs every chart already packaged
+	// on disk so it can check versions against the existing index.yaml
+	// before a single archive is pushed to storage.
+	var packages []*util.FileStat
+	for file := range util.PackageCharts(charts, tempDir, logger, p.Config.RepoURL) {
+		packages = append(packages, file)
+	}
+
 	//upload charts
 	var objectStore storage.ObjectStore
-	switch destinationURL.Scheme {
+	switch storageScheme(destinationURL) {
 	case "s3":
 		objectStore, err = s3.CreateS3ObjectStore(
 			&s3.Config{
-				AccessKey: p.Config.AWSAccessKey,
-				SecretKey: p.Config.AWSSecretKey,
-				Region:    p.Config.AWSRegion,
-				S3URI:     p.Config.StorageURL,
+				AccessKey:      p.Config.AWSAccessKey,
+				SecretKey:      p.Config.AWSSecretKey,
+				Region:         p.Config.AWSRegion,
+				Profile:        p.Config.AWSProfile,
+				Endpoint:       p.Config.AWSEndpoint,
+				DisableSSL:     p.Config.AWSDisableSSL,
+				ForcePathStyle: p.Config.AWSForcePathStyle,
+				ACL:            p.Config.AWSACL,
+				CacheControl:   p.Config.AWSCacheControl,
+				SSE:            p.Config.AWSSSE,
+				KMSKeyID:       p.Config.AWSKMSKeyID,
+				S3URI:          p.Config.StorageURL,
+			})
+		if err != nil {
+			return err
+		}
+	case "gs":
+		objectStore, err = gcs.CreateGCSObjectStore(
+			&gcs.Config{
+				CredentialsFile: p.Config.GCSCredentialsFile,
+				GCSURI:          p.Config.StorageURL,
+			})
+		if err != nil {
+			return err
+		}
+	case "azblob":
+		objectStore, err = azure.CreateAzureObjectStore(
+			&azure.Config{
+				Account:   p.Config.AzureStorageAccount,
+				AccessKey: p.Config.AzureStorageKey,
+				SASToken:  p.Config.AzureSASToken,
+				AzureURI:  p.Config.StorageURL,
 			})
 		if err != nil {
 			return err
@@ -73,8 +177,28 @@ func (p *Plugin) Exec() error {
 	default:
 		return fmt.Errorf("protocol %q not implemented yet", destinationURL.Scheme)
 	}
-	_, err = storeFiles(objectStore, packages, logger)
-	return err
+
+	ctx := context.Background()
+	uploadTimeout := time.Duration(p.Config.UploadTimeoutSeconds) * time.Second
+	upload := func() (map[string]string, error) {
+		in := make(chan *util.FileStat, len(packages))
+		for _, file := range packages {
+			in <- file
+		}
+		close(in)
+		_, digests, err := storeFiles(ctx, objectStore, in, logger, p.Config.Concurrency, uploadTimeout, p.Config.DryRun)
+		return digests, err
+	}
+
+	if p.Config.DryRun {
+		if _, err := upload(); err != nil {
+			return err
+		}
+		logger.Out.Println("dry-run: skipping index.yaml update")
+		return nil
+	}
+
+	return index.Update(ctx, objectStore, index.HelmMerger{}, tempDir, p.Config.RepoURL, p.Config.Force, p.Config.AcquireLock, upload, logger)
 }
 
 func (p *Plugin) debug() {
@@ -90,50 +214,157 @@ func (p *Plugin) debug() {
 	fmt.Printf("Repo URL: %s \n", p.Config.RepoURL)
 }
 
+// storageScheme normalizes a parsed storage-url into one of
+// supportedStorageSchemes. Azure Blob Storage accepts both the azblob://
+// scheme and a plain https://<account>.blob.core.windows.net/... URL.
+func storageScheme(u *url.URL) string {
+	if u.Scheme == "https" && strings.HasSuffix(u.Host, ".blob.core.windows.net") {
+		return "azblob"
+	}
+	return u.Scheme
+}
+
 func validateConfig(conf Config) error {
 	destinationURL, err := url.Parse(conf.StorageURL)
 	if err != nil {
 		return fmt.Errorf("could not parse storage-url %q", conf.StorageURL)
 	}
+	scheme := storageScheme(destinationURL)
 	for _, s := range supportedStorageSchemes {
-		if destinationURL.Scheme == s {
-			if s == "s3" {
-				//more conditions to validate
+		if scheme == s {
+			switch s {
+			case "s3":
 				if conf.AWSRegion == "" {
 					return fmt.Errorf("--aws-region required for s3 storage")
 				}
+				if _, _, _, err := s3.ResolveOptions(&s3.Config{
+					Endpoint:       conf.AWSEndpoint,
+					DisableSSL:     conf.AWSDisableSSL,
+					ForcePathStyle: conf.AWSForcePathStyle,
+					S3URI:          conf.StorageURL,
+				}); err != nil {
+					return err
+				}
+			case "azblob":
+				if conf.AzureStorageAccount == "" && destinationURL.Scheme == "azblob" {
+					return fmt.Errorf("--azure-storage-account required for azblob storage")
+				}
+				if conf.AzureStorageKey == "" && conf.AzureSASToken == "" {
+					return fmt.Errorf("--azure-storage-key or --azure-sas-token required for azblob storage")
+				}
 			}
-			break
+			return nil
 		}
-		return fmt.Errorf("storage-url does not have valid protocol %q, should be in %v", destinationURL.Scheme, supportedStorageSchemes)
 	}
-	return nil
+	return fmt.Errorf("storage-url does not have valid protocol %q, should be in %v", destinationURL.Scheme, supportedStorageSchemes)
 }
 
-func storeFiles(storage storage.ObjectStore, in chan *util.FileStat, logger *util.Logger) (int, error) {
-	concurrency := 5
-	var wg sync.WaitGroup
+// storeFiles uploads every file received from in, bounded to concurrency
+// workers, and returns once all of them have either succeeded or one has
+// failed permanently. On the first permanent failure it keeps draining in
+// so whatever goroutine is sending on it isn't left blocked on a send
+// nobody will ever receive.
+func storeFiles(ctx context.Context, store storage.ObjectStore, in chan *util.FileStat, logger *util.Logger, concurrency int, uploadTimeout time.Duration, dryRun bool) (int, map[string]string, error) {
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	var mu sync.Mutex
+	var storedFilesCount int32
+	digests := map[string]string{}
 
-	storedFilesCount := 0
-	var outerError error
 	for worker := 0; worker < concurrency; worker++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for file := range in {
-				if file.Err != nil {
-					logger.Err.Println(file.Err)
-					continue
-				}
-				err := storage.StoreFile(file, logger)
-				if err != nil {
-					outerError = err
-					return //bail worker as soon one upload fails
+		g.Go(func() error {
+			for {
+				select {
+				case <-ctx.Done():
+					drain(in)
+					return ctx.Err()
+				case file, ok := <-in:
+					if !ok {
+						return nil
+					}
+					if file.Err != nil {
+						logger.Err.Println(file.Err)
+						continue
+					}
+					if dryRun {
+						logger.Out.Printf("dry-run: would upload %s as %s\n", file.Path, file.Name)
+						atomic.AddInt32(&storedFilesCount, 1)
+						continue
+					}
+					if err := storeWithRetry(ctx, store, file, logger, uploadTimeout); err != nil {
+						drain(in)
+						return err
+					}
+					atomic.AddInt32(&storedFilesCount, 1)
+					mu.Lock()
+					digests[file.Name] = file.Digest
+					mu.Unlock()
 				}
-				storedFilesCount++
 			}
-		}()
+		})
+	}
+
+	err := g.Wait()
+	return int(storedFilesCount), digests, err
+}
+
+// drain discards whatever remains on in without blocking its sender.
+func drain(in chan *util.FileStat) {
+	for range in {
+	}
+}
+
+// storeWithRetry uploads file, retrying transient S3 errors (5xx,
+// throttling, request timeouts) with exponential backoff, similar to the
+// pacer rclone uses against S3-compatible backends.
+func storeWithRetry(ctx context.Context, store storage.ObjectStore, file *util.FileStat, logger *util.Logger, uploadTimeout time.Duration) error {
+	backoff := defaultRetryBackoff
+	var err error
+	for attempt := 1; attempt <= defaultUploadRetries; attempt++ {
+		attemptCtx := ctx
+		cancel := func() {}
+		if uploadTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, uploadTimeout)
+		}
+
+		err = store.StoreFile(attemptCtx, file, logger)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt == defaultUploadRetries || !isRetryable(err) {
+			return err
+		}
+
+		logger.Err.Printf("upload of %s failed (attempt %d/%d): %v, retrying in %s\n", file.Name, attempt, defaultUploadRetries, err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// isRetryable reports whether err looks like a transient failure worth
+// retrying: throttling, request timeouts, and 5xx-class S3 errors.
+func isRetryable(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() >= 500 {
+		return true
+	}
+	switch aerr.Code() {
+	case "RequestTimeout", "RequestTimeoutException", "Throttling", "ThrottlingException",
+		"SlowDown", "ServiceUnavailable", "InternalError", "500", "503":
+		return true
+	default:
+		return false
 	}
-	wg.Wait()
-	return storedFilesCount, outerError
 }